@@ -39,7 +39,23 @@ import (
 // from the PublicKey's Sum256 method.
 const PublicKeyHashSize = 32
 
+// SchemeID identifies a registered wire KEM Scheme. It is carried as
+// the Type tag of a self-describing key envelope (see RegisterScheme
+// and UnmarshalPublicKey/UnmarshalPrivateKey) and, once a scheme
+// supports negotiation, as one of the IDs advertised in Supported().
+//
+// The zero value is reserved to mean "no SchemeID set" rather than a
+// real scheme: RegisterScheme refuses id 0, so unlike every other
+// SchemeID, 0 can never collide with a registered scheme. publicKey
+// and privateKey rely on that to tell an envelope-validating key apart
+// from one that was built without its id being set.
+type SchemeID uint32
+
+// SchemeKyber768X25519 is the SchemeID of DefaultScheme.
+const SchemeKyber768X25519 SchemeID = 1
+
 var DefaultScheme = &scheme{
+	id:  SchemeKyber768X25519,
 	KEM: kem.Kyber768X25519,
 }
 
@@ -98,6 +114,11 @@ type PrivateKey interface {
 
 // Scheme provides a minimal abstraction around our KEM Scheme.
 type Scheme interface {
+	// ID returns this scheme's SchemeID, used to tag self-describing
+	// key envelopes and, in a NegotiatingScheme, to advertise and
+	// select among several KEMs.
+	ID() SchemeID
+
 	// GenerateKeypair generates a new KEM keypair using the provided
 	// entropy source.
 	GenerateKeypair(r io.Reader) (PrivateKey, PublicKey)
@@ -105,12 +126,21 @@ type Scheme interface {
 	// PublicKeyFromBytes returns a PublicKey using the provided
 	// bytes.
 	PublicKeyFromBytes(b []byte) (PublicKey, error)
+
+	// PrivateKeyFromProvider returns a PrivateKey backed by the
+	// out-of-process KeyProvider service at addr, so that code holding
+	// a Scheme value doesn't need a concrete-type assertion to reach it.
+	// See RemotePrivateKey in provider.go for what the returned
+	// PrivateKey forwards and what pubHint is used for.
+	PrivateKeyFromProvider(addr string, pubHint []byte) (PrivateKey, error)
 }
 
 type publicKey struct {
 	publicKey kem.PublicKey
 	KEM       kem.KEM
+	id        SchemeID
 	hash      [PublicKeyHashSize]byte
+	raw       *lockedBuffer
 }
 
 func (p *publicKey) KeyType() string {
@@ -137,19 +167,30 @@ func (p *publicKey) FromPEMFile(f string) error {
 func (p *publicKey) ToPEMFile(f string) error {
 	keyType := fmt.Sprintf("%s PUBLIC KEY", strings.ToUpper(p.KEM.String()))
 
-	if utils.CtIsZero(p.Bytes()) {
-		return fmt.Errorf("attempted to serialize scrubbed key")
-	}
-	blk := &pem.Block{
-		Type:  keyType,
-		Bytes: p.Bytes(),
-	}
-	return os.WriteFile(f, pem.EncodeToMemory(blk), 0600)
-}
-
-// XXX FIXME
+	var err error
+	p.WithBytes(func(raw []byte) {
+		if utils.CtIsZero(raw) {
+			err = fmt.Errorf("attempted to serialize scrubbed key")
+			return
+		}
+		blk := &pem.Block{
+			Type:  keyType,
+			Bytes: raw,
+		}
+		err = os.WriteFile(f, pem.EncodeToMemory(blk), 0600)
+	})
+	return err
+}
+
+// Reset wipes the cached raw key bytes from locked, off-heap memory and
+// drops the reference to the underlying KEM public key so the garbage
+// collector can reclaim whatever copies it holds internally.
 func (p *publicKey) Reset() {
-	p = nil
+	if p.raw != nil {
+		p.raw.Reset()
+		p.raw = nil
+	}
+	p.publicKey = nil
 }
 
 func (p *publicKey) Equal(publicKey PublicKey) bool {
@@ -161,20 +202,98 @@ func (p *publicKey) FromBytes(b []byte) error {
 	if err != nil {
 		return err
 	}
+	if p.raw != nil {
+		p.raw.Reset()
+		p.raw = nil
+	}
 	p.publicKey = publicKey
 	return nil
 }
 
+// ensureRaw copies the current public key into p.raw's locked buffer,
+// without itself handing back an unmanaged heap copy the way Bytes
+// does. Bytes and WithBytes both build on this.
+func (p *publicKey) ensureRaw() {
+	if p.publicKey == nil {
+		return
+	}
+	key := p.publicKey.Bytes()
+	if p.raw == nil {
+		lb, err := newLockedBuffer(len(key))
+		if err != nil {
+			panic(err)
+		}
+		p.raw = lb
+	}
+	if err := p.raw.set(key); err != nil {
+		panic(err)
+	}
+}
+
+// Bytes returns a copy of the raw public key. The copy is cached in a
+// lockedBuffer so that Reset has something concrete to wipe; callers
+// that want to avoid even that temporary heap copy should use
+// WithBytes instead.
 func (p *publicKey) Bytes() []byte {
-	return p.publicKey.Bytes()
+	p.ensureRaw()
+	if p.raw == nil {
+		return nil
+	}
+	return p.raw.bytes()
+}
+
+// WithBytes invokes fn with the public key's raw bytes without copying
+// them onto the regular Go heap first: unlike Bytes, it never produces
+// an unmanaged heap copy of its own. fn must not retain the slice it
+// is given.
+func (p *publicKey) WithBytes(fn func([]byte)) {
+	p.ensureRaw()
+	if p.raw == nil {
+		fn(nil)
+		return
+	}
+	p.raw.withBytes(fn)
 }
 
-func (p *publicKey) MarshalBinary() (data []byte, err error) {
-	return p.Bytes(), nil
+// RawBytes returns the raw KEM public key, with no self-describing
+// envelope. Use this for on-the-wire framing where the peer already
+// knows which scheme is in use; use MarshalBinary/UnmarshalBinary
+// instead when the scheme may not be known ahead of time.
+func (p *publicKey) RawBytes() []byte {
+	return p.Bytes()
 }
 
+// FromRawBytes is the raw-bytes counterpart to RawBytes.
+func (p *publicKey) FromRawBytes(b []byte) error {
+	return p.FromBytes(b)
+}
+
+// MarshalBinary wraps the raw public key in a self-describing envelope
+// tagged with this key's SchemeID, so that UnmarshalPublicKey can later
+// recover it without being told out-of-band which KEM produced it.
+func (p *publicKey) MarshalBinary() (data []byte, err error) {
+	p.WithBytes(func(raw []byte) {
+		data = (&keyEnvelope{Type: uint32(p.id), Data: raw}).marshal()
+	})
+	return data, nil
+}
+
+// UnmarshalBinary reads an envelope produced by MarshalBinary. The
+// envelope's Type tag must match this key's SchemeID, unless p.id is
+// still the reserved zero value (see SchemeID), in which case no
+// scheme was set ahead of time and the check is skipped; to recover a
+// key whose scheme isn't known ahead of time, use the package-level
+// UnmarshalPublicKey instead.
 func (p *publicKey) UnmarshalBinary(data []byte) error {
-	return p.FromBytes(data)
+	env, err := parseKeyEnvelope(data)
+	if err != nil {
+		return err
+	}
+	if p.id != 0 && env.Type != uint32(p.id) {
+		return fmt.Errorf("wire: key envelope type %d does not match expected %d", env.Type, p.id)
+	}
+	p.id = SchemeID(env.Type)
+	return p.FromBytes(env.Data)
 }
 
 func (p *publicKey) MarshalText() (text []byte, err error) {
@@ -186,13 +305,17 @@ func (p *publicKey) UnmarshalText(text []byte) error {
 }
 
 func (p *publicKey) Sum256() [32]byte {
-	p.hash = blake2b.Sum256(p.Bytes())
+	p.WithBytes(func(raw []byte) {
+		p.hash = blake2b.Sum256(raw)
+	})
 	return p.hash
 }
 
 type privateKey struct {
 	privateKey kem.Keypair
 	KEM        kem.KEM
+	id         SchemeID
+	raw        *lockedBuffer
 }
 
 func (p *privateKey) KeyType() string {
@@ -219,19 +342,34 @@ func (p *privateKey) FromPEMFile(f string) error {
 func (p *privateKey) ToPEMFile(f string) error {
 	keyType := fmt.Sprintf("%s PRIVATE KEY", strings.ToUpper(p.KEM.String()))
 
-	if utils.CtIsZero(p.Bytes()) {
-		return fmt.Errorf("attempted to serialize scrubbed key")
-	}
-	blk := &pem.Block{
-		Type:  keyType,
-		Bytes: p.Bytes(),
-	}
-	return os.WriteFile(f, pem.EncodeToMemory(blk), 0600)
-}
-
-// XXX FIXME
+	var err error
+	p.WithBytes(func(raw []byte) {
+		if utils.CtIsZero(raw) {
+			err = fmt.Errorf("attempted to serialize scrubbed key")
+			return
+		}
+		blk := &pem.Block{
+			Type:  keyType,
+			Bytes: raw,
+		}
+		err = os.WriteFile(f, pem.EncodeToMemory(blk), 0600)
+	})
+	return err
+}
+
+// Reset wipes the cached raw key bytes from locked, off-heap memory and
+// drops the reference to the underlying KEM keypair so the garbage
+// collector can reclaim whatever copies it holds internally. The
+// lockedBuffer this covers is the one Bytes/WithBytes fill and read
+// from and MarshalBinary reads through; slices already returned by
+// Bytes are separate copies that Reset does not wipe, and it does not
+// reach inside the nyquist kem.Keypair's own internal state.
 func (p *privateKey) Reset() {
-	p = nil
+	if p.raw != nil {
+		p.raw.Reset()
+		p.raw = nil
+	}
+	p.privateKey = nil
 }
 
 func (p *privateKey) PublicKey() PublicKey {
@@ -239,6 +377,7 @@ func (p *privateKey) PublicKey() PublicKey {
 		publicKey: p.privateKey.Public(),
 		hash:      blake2b.Sum256(p.privateKey.Public().Bytes()),
 		KEM:       p.KEM,
+		id:        p.id,
 	}
 }
 
@@ -247,24 +386,104 @@ func (p *privateKey) FromBytes(b []byte) error {
 	if err != nil {
 		return err
 	}
+	if p.raw != nil {
+		p.raw.Reset()
+		p.raw = nil
+	}
 	p.privateKey = privateKey
 	return nil
 }
 
-func (p *privateKey) Bytes() []byte {
+// ensureRaw copies the current private key into p.raw's locked buffer,
+// wiping the throwaway MarshalBinary copy as soon as it's been copied
+// in. Bytes and WithBytes both build on this so that neither leaves an
+// extra, unmanaged heap copy behind.
+func (p *privateKey) ensureRaw() {
+	if p.privateKey == nil {
+		return
+	}
 	key, err := p.privateKey.MarshalBinary()
 	if err != nil {
 		panic(err)
 	}
-	return key
+	if p.raw == nil {
+		lb, lerr := newLockedBuffer(len(key))
+		if lerr != nil {
+			panic(lerr)
+		}
+		p.raw = lb
+	}
+	if err := p.raw.set(key); err != nil {
+		panic(err)
+	}
+	wipe(key)
 }
 
-func (p *privateKey) MarshalBinary() (data []byte, err error) {
-	return p.Bytes(), nil
+// Bytes returns a copy of the raw private key; the caller is expected
+// to wipe it once done. The copy is cached in a lockedBuffer so that
+// Reset has something concrete to wipe; callers that want to avoid
+// even that temporary heap copy should use WithBytes instead.
+func (p *privateKey) Bytes() []byte {
+	p.ensureRaw()
+	if p.raw == nil {
+		return nil
+	}
+	return p.raw.bytes()
+}
+
+// WithBytes invokes fn with the private key's raw bytes without
+// copying them onto the regular Go heap first: unlike Bytes, it never
+// produces an unmanaged heap copy of its own. fn must not retain the
+// slice it is given, since it points into mlock'd memory that Reset
+// can free at any time.
+func (p *privateKey) WithBytes(fn func([]byte)) {
+	p.ensureRaw()
+	if p.raw == nil {
+		fn(nil)
+		return
+	}
+	p.raw.withBytes(fn)
+}
+
+// RawBytes returns the raw KEM private key, with no self-describing
+// envelope. Use this for on-the-wire framing where the peer already
+// knows which scheme is in use; use MarshalBinary/UnmarshalBinary
+// instead when the scheme may not be known ahead of time.
+func (p *privateKey) RawBytes() []byte {
+	return p.Bytes()
 }
 
+// FromRawBytes is the raw-bytes counterpart to RawBytes.
+func (p *privateKey) FromRawBytes(b []byte) error {
+	return p.FromBytes(b)
+}
+
+// MarshalBinary wraps the raw private key in a self-describing envelope
+// tagged with this key's SchemeID, so that UnmarshalPrivateKey can later
+// recover it without being told out-of-band which KEM produced it.
+func (p *privateKey) MarshalBinary() (data []byte, err error) {
+	p.WithBytes(func(raw []byte) {
+		data = (&keyEnvelope{Type: uint32(p.id), Data: raw}).marshal()
+	})
+	return data, nil
+}
+
+// UnmarshalBinary reads an envelope produced by MarshalBinary. The
+// envelope's Type tag must match this key's SchemeID, unless p.id is
+// still the reserved zero value (see SchemeID), in which case no
+// scheme was set ahead of time and the check is skipped; to recover a
+// key whose scheme isn't known ahead of time, use the package-level
+// UnmarshalPrivateKey instead.
 func (p *privateKey) UnmarshalBinary(data []byte) error {
-	return p.FromBytes(data)
+	env, err := parseKeyEnvelope(data)
+	if err != nil {
+		return err
+	}
+	if p.id != 0 && env.Type != uint32(p.id) {
+		return fmt.Errorf("wire: key envelope type %d does not match expected %d", env.Type, p.id)
+	}
+	p.id = SchemeID(env.Type)
+	return p.FromBytes(env.Data)
 }
 
 func (p *privateKey) MarshalText() (text []byte, err error) {
@@ -277,11 +496,16 @@ func (p *privateKey) UnmarshalText(text []byte) error {
 
 
 type scheme struct {
+	id  SchemeID
 	KEM kem.KEM
 }
 
 var _ Scheme = (*scheme)(nil)
 
+func (s *scheme) ID() SchemeID {
+	return s.id
+}
+
 func (s *scheme) PrivateKeyFromBytes(b []byte) (PrivateKey, error) {
 	privKey, err := s.KEM.ParsePrivateKey(b)
 	if err != nil {
@@ -290,6 +514,7 @@ func (s *scheme) PrivateKeyFromBytes(b []byte) (PrivateKey, error) {
 	return &privateKey{
 		privateKey: privKey,
 		KEM:        s.KEM,
+		id:         s.id,
 	}, nil
 }
 
@@ -301,6 +526,7 @@ func (s *scheme) PublicKeyFromBytes(b []byte) (PublicKey, error) {
 	return &publicKey{
 		publicKey: pubKey,
 		KEM:       s.KEM,
+		id:        s.id,
 	}, nil
 }
 
@@ -376,6 +602,7 @@ func (s *scheme) GenerateKeypair(r io.Reader) (PrivateKey, PublicKey) {
 	privk := &privateKey{
 		KEM:        s.KEM,
 		privateKey: k,
+		id:         s.id,
 	}
 	return privk, privk.PublicKey()
 }
@@ -0,0 +1,119 @@
+//go:build linux
+
+// lockedbuffer_linux.go - mlock'd, off-heap storage for KEM key bytes.
+// Copyright (C) 2022  David Anthony Stainton
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockedBuffer is an mlock'd, off-heap byte buffer, modeled on
+// awnumar/memguard's LockedBuffer. Backing memory comes from
+// mmap(MAP_ANON|MAP_PRIVATE) instead of the Go heap, so the GC never
+// copies or relocates it, and it is mlock'd so it is never written to
+// swap. Reset wipes it in place rather than waiting on the garbage
+// collector to eventually reclaim and forget it.
+type lockedBuffer struct {
+	data []byte
+}
+
+// newLockedBuffer allocates an mlock'd buffer of size bytes and
+// registers a finalizer so it is still wiped and released if the
+// caller forgets to call Reset.
+func newLockedBuffer(size int) (*lockedBuffer, error) {
+	if size == 0 {
+		return &lockedBuffer{}, nil
+	}
+	b, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, fmt.Errorf("wire: mmap locked buffer: %w", err)
+	}
+	if err := unix.Mlock(b); err != nil {
+		unix.Munmap(b)
+		return nil, fmt.Errorf("wire: mlock locked buffer: %w", err)
+	}
+	lb := &lockedBuffer{data: b}
+	runtime.SetFinalizer(lb, (*lockedBuffer).Reset)
+	return lb, nil
+}
+
+// set replaces the buffer's contents with a copy of b, reallocating if
+// the size has changed.
+func (l *lockedBuffer) set(b []byte) error {
+	if len(b) != len(l.data) {
+		nb, err := newLockedBuffer(len(b))
+		if err != nil {
+			return err
+		}
+		l.Reset()
+		*l = *nb
+		// newLockedBuffer registered a finalizer on nb, not on l, so
+		// moving nb's fields into l with the struct copy above leaves
+		// that finalizer pointing at an object nothing references any
+		// more. Once nb became unreachable the GC could run it and
+		// Munmap the backing array l.data still points at and is
+		// actively using. Re-target the finalizer at l instead.
+		runtime.SetFinalizer(nb, nil)
+		if l.data != nil {
+			runtime.SetFinalizer(l, (*lockedBuffer).Reset)
+		}
+	}
+	copy(l.data, b)
+	return nil
+}
+
+// bytes returns a copy of the locked buffer's contents; the caller is
+// expected to wipe it (or use withBytes instead) once done with it.
+func (l *lockedBuffer) bytes() []byte {
+	if l.data == nil {
+		return nil
+	}
+	out := make([]byte, len(l.data))
+	copy(out, l.data)
+	return out
+}
+
+// withBytes invokes fn with the locked buffer's contents directly,
+// without copying them onto the regular Go heap first. fn must not
+// retain the slice it is given.
+func (l *lockedBuffer) withBytes(fn func([]byte)) {
+	fn(l.data)
+}
+
+//go:noinline
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Reset overwrites the buffer with zeros and releases the underlying
+// mapping. It is safe to call more than once.
+func (l *lockedBuffer) Reset() {
+	if l.data == nil {
+		return
+	}
+	wipe(l.data)
+	unix.Munlock(l.data)
+	unix.Munmap(l.data)
+	l.data = nil
+	runtime.SetFinalizer(l, nil)
+}
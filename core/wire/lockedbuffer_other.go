@@ -0,0 +1,71 @@
+//go:build !linux
+
+// lockedbuffer_other.go - fallback KEM key storage for platforms without
+// mlock/mmap support.
+// Copyright (C) 2022  David Anthony Stainton
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import "runtime"
+
+// lockedBuffer is the non-Linux fallback: plain heap memory that is
+// still wiped on Reset, but is not mlock'd or mapped off-heap, since
+// those primitives aren't portable. See lockedbuffer_linux.go for the
+// hardened implementation.
+type lockedBuffer struct {
+	data []byte
+}
+
+func newLockedBuffer(size int) (*lockedBuffer, error) {
+	return &lockedBuffer{data: make([]byte, size)}, nil
+}
+
+func (l *lockedBuffer) set(b []byte) error {
+	if len(b) != len(l.data) {
+		l.data = make([]byte, len(b))
+	}
+	copy(l.data, b)
+	return nil
+}
+
+func (l *lockedBuffer) bytes() []byte {
+	if l.data == nil {
+		return nil
+	}
+	out := make([]byte, len(l.data))
+	copy(out, l.data)
+	return out
+}
+
+func (l *lockedBuffer) withBytes(fn func([]byte)) {
+	fn(l.data)
+}
+
+//go:noinline
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func (l *lockedBuffer) Reset() {
+	if l.data == nil {
+		return
+	}
+	wipe(l.data)
+	l.data = nil
+	runtime.SetFinalizer(l, nil)
+}
@@ -0,0 +1,129 @@
+// lockedbuffer_test.go - Exercise key Reset under GC pressure.
+// Copyright (C) 2022  David Anthony Stainton
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"bytes"
+	"crypto/rand"
+	"runtime"
+	"testing"
+)
+
+// TestPrivateKeyResetWipesLockedBuffer repeatedly generates keypairs,
+// captures the raw private key bytes, calls Reset, and forces a GC
+// cycle, confirming that neither the locked buffer nor Bytes() still
+// exposes the key material afterwards.
+func TestPrivateKeyResetWipesLockedBuffer(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		priv, _ := DefaultScheme.GenerateKeypair(rand.Reader)
+		pk := priv.(*privateKey)
+
+		raw := pk.Bytes()
+		if len(raw) == 0 {
+			t.Fatal("generated private key has no bytes")
+		}
+		if pk.raw == nil {
+			t.Fatal("Bytes() did not populate the locked buffer")
+		}
+
+		pk.Reset()
+		runtime.GC()
+
+		if pk.raw != nil {
+			t.Fatal("Reset did not clear the locked buffer reference")
+		}
+		if pk.privateKey != nil {
+			t.Fatal("Reset did not drop the underlying keypair")
+		}
+		if got := pk.Bytes(); got != nil {
+			t.Fatalf("Bytes() after Reset returned %d bytes, want none", len(got))
+		}
+		if bytes.Equal(raw, make([]byte, len(raw))) {
+			t.Fatal("generated key was all zeros; test is not exercising real key material")
+		}
+	}
+}
+
+// TestLockedBufferWipeZeroesBackingArray confirms that wipe() actually
+// scrubs the lockedBuffer's own backing array, not just a Bytes()
+// copy of it. It reads lb.data directly through withBytes and after
+// wipe, and only calls Reset (deferred) once it has finished reading:
+// Reset additionally munmaps the memory on Linux, and reading mmap'd
+// memory after it has been unmapped is undefined behavior.
+func TestLockedBufferWipeZeroesBackingArray(t *testing.T) {
+	lb, err := newLockedBuffer(32)
+	if err != nil {
+		t.Fatalf("newLockedBuffer: %v", err)
+	}
+	defer lb.Reset()
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	if err := lb.set(key); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	lb.withBytes(func(raw []byte) {
+		if bytes.Equal(raw, make([]byte, len(raw))) {
+			t.Fatal("locked buffer holds all zeros before wipe; test is not exercising real key material")
+		}
+		if len(raw) == 0 || &raw[0] != &lb.data[0] {
+			t.Fatal("withBytes did not hand back the real backing array")
+		}
+	})
+
+	// Wipe the real backing array directly, not a copy, and read it
+	// straight back while it is still mapped.
+	wipe(lb.data)
+	if !bytes.Equal(lb.data, make([]byte, len(lb.data))) {
+		t.Fatal("wipe did not zero the locked buffer's real backing array")
+	}
+}
+
+// TestLockedBufferSetResizeDoesNotUseAfterFree exercises set()'s resize
+// branch, which swaps in a new backing array via a struct copy from a
+// throwaway lockedBuffer. That throwaway object had its own finalizer
+// registered by newLockedBuffer; if set left the finalizer pointing at
+// the (now unreachable) throwaway instead of retargeting it at l, a GC
+// cycle could run it and Munmap the backing array l still shares,
+// corrupting or crashing the read below.
+func TestLockedBufferSetResizeDoesNotUseAfterFree(t *testing.T) {
+	lb, err := newLockedBuffer(8)
+	if err != nil {
+		t.Fatalf("newLockedBuffer: %v", err)
+	}
+	defer lb.Reset()
+
+	first := bytes.Repeat([]byte{0xaa}, 8)
+	if err := lb.set(first); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	second := bytes.Repeat([]byte{0x55}, 32)
+	if err := lb.set(second); err != nil {
+		t.Fatalf("set (resize): %v", err)
+	}
+
+	runtime.GC()
+	runtime.GC()
+
+	if !bytes.Equal(lb.data, second) {
+		t.Fatalf("lockedBuffer contents corrupted after resize+GC: got %x, want %x", lb.data, second)
+	}
+}
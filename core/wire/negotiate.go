@@ -0,0 +1,78 @@
+// negotiate.go - Runtime KEM negotiation across multiple wire Schemes.
+// Copyright (C) 2022  David Anthony Stainton
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import "fmt"
+
+// NegotiatingScheme aggregates an ordered list of concrete KEM Schemes,
+// most preferred first, so a peer can advertise everything it supports
+// and the other side can pick the strongest one they have in common.
+// This is the crypto-agility path for rolling out new post-quantum KEMs
+// across the mixnet without a flag-day cutover: every peer advertises
+// its Supported IDs, and Select resolves the pair down to a single
+// concrete Scheme for the rest of the wire protocol to use.
+//
+// NegotiatingScheme does not itself implement Scheme, since "negotiate
+// then use" is a two-step process with no single GenerateKeypair to
+// offer until a Scheme has actually been selected.
+//
+// This slice of the tree has no handshake/session state machine for
+// the wire protocol yet (only the KEM key types in kem.go), so nothing
+// here calls Supported/Select during an actual handshake: DefaultScheme
+// is still the only scheme any caller in this tree ends up using.
+// Supported/Select are the negotiation primitives a handshake's first
+// prologue message and its response would call once that state machine
+// exists; wire them in there rather than duplicating negotiation logic
+// at each call site.
+type NegotiatingScheme struct {
+	schemes []Scheme
+}
+
+// NewNegotiatingScheme returns a NegotiatingScheme that offers schemes
+// in the given order of preference, most preferred first.
+func NewNegotiatingScheme(schemes ...Scheme) *NegotiatingScheme {
+	return &NegotiatingScheme{schemes: schemes}
+}
+
+// Supported returns the SchemeIDs this side is willing to use, in order
+// of preference: the list a peer passes to Select on the other side.
+// No handshake in this package sends it.
+func (n *NegotiatingScheme) Supported() []SchemeID {
+	ids := make([]SchemeID, 0, len(n.schemes))
+	for _, s := range n.schemes {
+		ids = append(ids, s.ID())
+	}
+	return ids
+}
+
+// Select walks this side's preference list and returns the first Scheme
+// that also appears in peerSupported, i.e. the strongest scheme both
+// sides can use. It returns an error if the two sides have no KEM in
+// common, including the PQ-only-on-one-side case, so the handshake
+// fails closed instead of silently downgrading.
+func (n *NegotiatingScheme) Select(peerSupported []SchemeID) (Scheme, error) {
+	peer := make(map[SchemeID]bool, len(peerSupported))
+	for _, id := range peerSupported {
+		peer[id] = true
+	}
+	for _, s := range n.schemes {
+		if peer[s.ID()] {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("wire: no KEM scheme in common with peer (peer offered %v)", peerSupported)
+}
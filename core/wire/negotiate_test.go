@@ -0,0 +1,49 @@
+// negotiate_test.go - Tests for NegotiatingScheme's Select.
+// Copyright (C) 2022  David Anthony Stainton
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import "testing"
+
+type stubScheme struct {
+	id SchemeID
+	Scheme
+}
+
+func (s *stubScheme) ID() SchemeID { return s.id }
+
+func TestNegotiatingSchemeSelect(t *testing.T) {
+	strong := &stubScheme{id: 3}
+	medium := &stubScheme{id: 2}
+	weak := &stubScheme{id: 1}
+	n := NewNegotiatingScheme(strong, medium, weak)
+
+	if got := n.Supported(); len(got) != 3 || got[0] != 3 || got[1] != 2 || got[2] != 1 {
+		t.Fatalf("Supported() = %v, want [3 2 1]", got)
+	}
+
+	selected, err := n.Select([]SchemeID{1, 2})
+	if err != nil {
+		t.Fatalf("Select with overlap returned error: %v", err)
+	}
+	if selected.ID() != medium.ID() {
+		t.Fatalf("Select picked %v, want the strongest common scheme (id 2)", selected.ID())
+	}
+
+	if _, err := n.Select([]SchemeID{99}); err == nil {
+		t.Fatal("Select with no overlap should have returned an error")
+	}
+}
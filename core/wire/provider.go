@@ -0,0 +1,202 @@
+// provider.go - Out-of-process KEM private key provider.
+// Copyright (C) 2022  David Anthony Stainton
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/katzenpost/nyquist/kem"
+)
+
+// keyProviderURIScheme prefixes the opaque reference a RemotePrivateKey
+// serializes as, in place of key material.
+const keyProviderURIScheme = "kem-provider://"
+
+// KeyProviderClient is the transport contract a RemotePrivateKey needs
+// from an out-of-process signer/decapsulator, modeled on the ocicrypt
+// keyprovider gRPC design: Decapsulate turns a KEM ciphertext into the
+// shared secret without the plaintext private key ever entering this
+// process, and PublicKey returns the provider's raw public key bytes.
+// The concrete implementation (a generated gRPC client dialed over a
+// Unix-socket or TCP endpoint with mTLS) lives outside this package so
+// wire has no hard gRPC dependency; it is supplied through
+// KeyProviderDialer.
+type KeyProviderClient interface {
+	// Decapsulate returns the shared secret for ciphertext, computed by
+	// the provider without revealing the private key.
+	Decapsulate(ciphertext []byte) (sharedSecret []byte, err error)
+
+	// PublicKey returns the provider's raw public key bytes.
+	PublicKey() ([]byte, error)
+
+	// Close releases the connection to the provider.
+	Close() error
+}
+
+// KeyProviderDialer connects to the KeyProvider service at addr (a
+// Unix-socket path or host:port). Nothing in this package sets it: it
+// stays nil, and every PrivateKeyFromProvider call and kem-provider://
+// FromBytes fails, until other code assigns a concrete dialer to it.
+var KeyProviderDialer func(addr string) (KeyProviderClient, error)
+
+func dialKeyProvider(addr string) (KeyProviderClient, error) {
+	if KeyProviderDialer == nil {
+		return nil, fmt.Errorf("wire: no KeyProviderDialer registered; link in a KeyProvider client before using kem-provider:// keys")
+	}
+	return KeyProviderDialer(addr)
+}
+
+// RemotePrivateKey implements PrivateKey by forwarding every
+// secret-touching operation to an external KeyProviderClient, so the
+// plaintext private key never enters this process's memory. Bytes and
+// MarshalBinary return an opaque "kem-provider://" URI reference rather
+// than key material.
+//
+// This file is the client-side scaffold only: it defines the
+// KeyProviderClient contract and RemotePrivateKey's use of it, but does
+// not itself define the KeyProvider gRPC service or wire a concrete
+// dialer into KeyProviderDialer -- that belongs in server bootstrap
+// code, which isn't part of this slice of the tree. Nothing in the
+// handshake or server bootstrap paths constructs a RemotePrivateKey
+// yet; that integration is still to do once this tree has a handshake
+// state machine and a bootstrap entry point to wire it through.
+type RemotePrivateKey struct {
+	id      SchemeID
+	KEM     kem.KEM
+	addr    string
+	client  KeyProviderClient
+	pubHint []byte
+}
+
+var _ PrivateKey = (*RemotePrivateKey)(nil)
+
+// PrivateKeyFromProvider returns a PrivateKey backed by the KeyProvider
+// service at addr. pubHint, if non-nil, is the provider's public key
+// learned out of band (e.g. from the mix descriptor) and is returned by
+// PublicKey without a round trip; otherwise it is fetched from the
+// provider on first use. No private key material ever enters this
+// process.
+func (s *scheme) PrivateKeyFromProvider(addr string, pubHint []byte) (PrivateKey, error) {
+	client, err := dialKeyProvider(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &RemotePrivateKey{
+		id:      s.id,
+		KEM:     s.KEM,
+		addr:    addr,
+		client:  client,
+		pubHint: pubHint,
+	}, nil
+}
+
+func (p *RemotePrivateKey) KeyType() string {
+	return fmt.Sprintf("%s PRIVATE KEY (remote)", strings.ToUpper(p.KEM.String()))
+}
+
+// Reset closes the connection to the key provider. Unlike privateKey,
+// there is no local key material to wipe: it never left the provider.
+func (p *RemotePrivateKey) Reset() {
+	if p.client != nil {
+		p.client.Close()
+		p.client = nil
+	}
+}
+
+// Bytes returns the opaque "kem-provider://" reference used to
+// reconstruct this key, never the key material itself.
+func (p *RemotePrivateKey) Bytes() []byte {
+	return []byte(keyProviderURIScheme + p.addr)
+}
+
+// FromBytes parses a "kem-provider://" reference produced by Bytes and
+// dials the referenced provider.
+func (p *RemotePrivateKey) FromBytes(b []byte) error {
+	uri := string(b)
+	if !strings.HasPrefix(uri, keyProviderURIScheme) {
+		return fmt.Errorf("wire: not a kem-provider URI: %q", uri)
+	}
+	addr := strings.TrimPrefix(uri, keyProviderURIScheme)
+	client, err := dialKeyProvider(addr)
+	if err != nil {
+		return err
+	}
+	if p.client != nil {
+		p.client.Close()
+	}
+	p.addr = addr
+	p.client = client
+	return nil
+}
+
+func (p *RemotePrivateKey) MarshalBinary() (data []byte, err error) {
+	return (&keyEnvelope{Type: uint32(p.id), Data: p.Bytes()}).marshal(), nil
+}
+
+func (p *RemotePrivateKey) UnmarshalBinary(data []byte) error {
+	env, err := parseKeyEnvelope(data)
+	if err != nil {
+		return err
+	}
+	if p.id != 0 && env.Type != uint32(p.id) {
+		return fmt.Errorf("wire: key envelope type %d does not match expected %d", env.Type, p.id)
+	}
+	p.id = SchemeID(env.Type)
+	return p.FromBytes(env.Data)
+}
+
+func (p *RemotePrivateKey) MarshalText() (text []byte, err error) {
+	return p.Bytes(), nil
+}
+
+func (p *RemotePrivateKey) UnmarshalText(text []byte) error {
+	return p.FromBytes(text)
+}
+
+// PublicKey returns the PublicKey corresponding to this remote private
+// key, using pubHint if it was supplied to PrivateKeyFromProvider, or
+// fetching it from the provider otherwise. It panics if called after
+// Reset, since at that point there is neither a cached hint nor a live
+// connection left to ask.
+func (p *RemotePrivateKey) PublicKey() PublicKey {
+	raw := p.pubHint
+	if raw == nil {
+		if p.client == nil {
+			panic("wire: RemotePrivateKey.PublicKey called after Reset")
+		}
+		var err error
+		raw, err = p.client.PublicKey()
+		if err != nil {
+			panic(err)
+		}
+		p.pubHint = raw
+	}
+	pub, err := (&scheme{id: p.id, KEM: p.KEM}).PublicKeyFromBytes(raw)
+	if err != nil {
+		panic(err)
+	}
+	return pub
+}
+
+// Decapsulate forwards ciphertext to the key provider and returns the
+// resulting shared secret. It is not part of the PrivateKey interface,
+// so a caller reaches it by type-asserting a PrivateKey to
+// *RemotePrivateKey; nothing in this package calls it.
+func (p *RemotePrivateKey) Decapsulate(ciphertext []byte) ([]byte, error) {
+	return p.client.Decapsulate(ciphertext)
+}
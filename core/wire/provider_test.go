@@ -0,0 +1,95 @@
+// provider_test.go - Tests for RemotePrivateKey.
+// Copyright (C) 2022  David Anthony Stainton
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import "testing"
+
+type fakeKeyProviderClient struct {
+	pub    []byte
+	closed bool
+}
+
+func (c *fakeKeyProviderClient) Decapsulate(ciphertext []byte) ([]byte, error) {
+	out := make([]byte, len(ciphertext))
+	for i, b := range ciphertext {
+		out[i] = b ^ 0xff
+	}
+	return out, nil
+}
+
+func (c *fakeKeyProviderClient) PublicKey() ([]byte, error) {
+	return c.pub, nil
+}
+
+func (c *fakeKeyProviderClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRemotePrivateKeyDecapsulateAndBytes(t *testing.T) {
+	fake := &fakeKeyProviderClient{pub: []byte{1, 2, 3, 4}}
+	prev := KeyProviderDialer
+	KeyProviderDialer = func(addr string) (KeyProviderClient, error) {
+		return fake, nil
+	}
+	defer func() { KeyProviderDialer = prev }()
+
+	priv, err := DefaultScheme.PrivateKeyFromProvider("unix:///tmp/kemprovider.sock", nil)
+	if err != nil {
+		t.Fatalf("PrivateKeyFromProvider: %v", err)
+	}
+	remote := priv.(*RemotePrivateKey)
+
+	secret, err := remote.Decapsulate([]byte{0x00, 0xf0})
+	if err != nil {
+		t.Fatalf("Decapsulate: %v", err)
+	}
+	if secret[0] != 0xff || secret[1] != 0x0f {
+		t.Fatalf("Decapsulate returned %v, want forwarded fake result", secret)
+	}
+
+	if got := string(priv.Bytes()); got != "kem-provider:///tmp/kemprovider.sock" {
+		t.Fatalf("Bytes() = %q, want the kem-provider URI", got)
+	}
+}
+
+func TestRemotePrivateKeyPublicKeyAfterResetPanics(t *testing.T) {
+	fake := &fakeKeyProviderClient{pub: []byte{1, 2, 3, 4}}
+	prev := KeyProviderDialer
+	KeyProviderDialer = func(addr string) (KeyProviderClient, error) {
+		return fake, nil
+	}
+	defer func() { KeyProviderDialer = prev }()
+
+	priv, err := DefaultScheme.PrivateKeyFromProvider("unix:///tmp/kemprovider.sock", nil)
+	if err != nil {
+		t.Fatalf("PrivateKeyFromProvider: %v", err)
+	}
+	remote := priv.(*RemotePrivateKey)
+
+	priv.Reset()
+	if !fake.closed {
+		t.Fatal("Reset did not close the provider connection")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("PublicKey after Reset should panic with no hint and no connection left")
+		}
+	}()
+	remote.PublicKey()
+}
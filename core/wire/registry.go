@@ -0,0 +1,130 @@
+// registry.go - Self-describing, algorithm-agnostic wire key envelopes.
+// Copyright (C) 2022  David Anthony Stainton
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// keyEnvelope is the wrapper written by MarshalBinary and read by
+// UnmarshalBinary: a Type tag identifying the KEM scheme that produced
+// Data, so mixed-KEM deployments can tell keys apart without any
+// out-of-band configuration and old keys can be migrated without a
+// flag-day cutover. The layout is a fixed 4 byte big endian Type
+// followed by Data; a full protobuf/CBOR encoding would be overkill
+// for a struct with exactly these two fields.
+type keyEnvelope struct {
+	Type uint32
+	Data []byte
+}
+
+func (e *keyEnvelope) marshal() []byte {
+	out := make([]byte, 4+len(e.Data))
+	binary.BigEndian.PutUint32(out[:4], e.Type)
+	copy(out[4:], e.Data)
+	return out
+}
+
+func parseKeyEnvelope(b []byte) (*keyEnvelope, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("wire: key envelope too short: %d bytes", len(b))
+	}
+	return &keyEnvelope{
+		Type: binary.BigEndian.Uint32(b[:4]),
+		Data: b[4:],
+	}, nil
+}
+
+// schemeEntry is what RegisterScheme stores: the Scheme itself plus the
+// constructors needed to rebuild its PublicKey/PrivateKey types from
+// raw envelope Data, mirroring libp2p's PubKeyUnmarshallers map.
+type schemeEntry struct {
+	scheme        Scheme
+	unmarshalPub  func([]byte) (PublicKey, error)
+	unmarshalPriv func([]byte) (PrivateKey, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[uint32]*schemeEntry)
+)
+
+// RegisterScheme makes scheme reachable by UnmarshalPublicKey and
+// UnmarshalPrivateKey under the envelope Type tag id. id must stay
+// stable across releases: reusing it for a different scheme will make
+// old serialized keys unmarshal as the wrong algorithm. id 0 is
+// reserved to mean "no SchemeID set" (see SchemeID) and is refused
+// here so that reservation can never be violated by a registered
+// scheme.
+func RegisterScheme(id uint32, scheme Scheme, unmarshalPub func([]byte) (PublicKey, error), unmarshalPriv func([]byte) (PrivateKey, error)) {
+	if id == 0 {
+		panic("wire: RegisterScheme: id 0 is reserved for \"no SchemeID set\"")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[id] = &schemeEntry{
+		scheme:        scheme,
+		unmarshalPub:  unmarshalPub,
+		unmarshalPriv: unmarshalPriv,
+	}
+}
+
+func lookupScheme(id uint32) (*schemeEntry, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	e, ok := registry[id]
+	return e, ok
+}
+
+// UnmarshalPublicKey parses a self-describing envelope produced by a
+// PublicKey's MarshalBinary and dispatches on its Type tag to the
+// registered Scheme that can reconstruct it. Use this instead of a
+// specific Scheme's PublicKeyFromBytes when the algorithm in use isn't
+// known ahead of time, e.g. in a mixed-KEM deployment.
+func UnmarshalPublicKey(b []byte) (PublicKey, error) {
+	env, err := parseKeyEnvelope(b)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := lookupScheme(env.Type)
+	if !ok {
+		return nil, fmt.Errorf("wire: no scheme registered for key type %d", env.Type)
+	}
+	return entry.unmarshalPub(env.Data)
+}
+
+// UnmarshalPrivateKey is the PrivateKey analogue of UnmarshalPublicKey.
+func UnmarshalPrivateKey(b []byte) (PrivateKey, error) {
+	env, err := parseKeyEnvelope(b)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := lookupScheme(env.Type)
+	if !ok {
+		return nil, fmt.Errorf("wire: no scheme registered for key type %d", env.Type)
+	}
+	return entry.unmarshalPriv(env.Data)
+}
+
+func init() {
+	RegisterScheme(uint32(SchemeKyber768X25519), DefaultScheme,
+		func(b []byte) (PublicKey, error) { return DefaultScheme.PublicKeyFromBytes(b) },
+		func(b []byte) (PrivateKey, error) { return DefaultScheme.PrivateKeyFromBytes(b) },
+	)
+}
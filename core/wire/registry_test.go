@@ -0,0 +1,99 @@
+// registry_test.go - Tests for the self-describing key envelope registry.
+// Copyright (C) 2022  David Anthony Stainton
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package wire
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+// testSchemeID is a second Type tag registered alongside
+// SchemeKyber768X25519 so dispatch tests actually exercise the
+// registry picking between entries rather than the only one present.
+const testSchemeID = 0xfeed
+
+func init() {
+	RegisterScheme(testSchemeID, DefaultScheme,
+		func(b []byte) (PublicKey, error) { return DefaultScheme.PublicKeyFromBytes(b) },
+		func(b []byte) (PrivateKey, error) { return DefaultScheme.PrivateKeyFromBytes(b) },
+	)
+}
+
+func TestRegisterSchemeDispatchesByTypeTag(t *testing.T) {
+	_, pub := DefaultScheme.GenerateKeypair(rand.Reader)
+
+	envelope := (&keyEnvelope{Type: testSchemeID, Data: pub.Bytes()}).marshal()
+	got, err := UnmarshalPublicKey(envelope)
+	if err != nil {
+		t.Fatalf("UnmarshalPublicKey: %v", err)
+	}
+	if !got.Equal(pub) {
+		t.Fatal("UnmarshalPublicKey did not dispatch to the registered scheme's unmarshaller")
+	}
+}
+
+func TestUnmarshalUnknownTypeTagFails(t *testing.T) {
+	envelope := (&keyEnvelope{Type: 0xffffffff, Data: []byte{1, 2, 3}}).marshal()
+
+	if _, err := UnmarshalPublicKey(envelope); err == nil {
+		t.Fatal("UnmarshalPublicKey with an unregistered type tag should have failed")
+	}
+	if _, err := UnmarshalPrivateKey(envelope); err == nil {
+		t.Fatal("UnmarshalPrivateKey with an unregistered type tag should have failed")
+	}
+}
+
+func TestRegisterSchemeRejectsZeroID(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("RegisterScheme(0, ...) should have panicked: 0 is reserved for \"no SchemeID set\"")
+		}
+	}()
+	RegisterScheme(0, DefaultScheme,
+		func(b []byte) (PublicKey, error) { return DefaultScheme.PublicKeyFromBytes(b) },
+		func(b []byte) (PrivateKey, error) { return DefaultScheme.PrivateKeyFromBytes(b) },
+	)
+}
+
+func TestMarshalUnmarshalRoundTrip(t *testing.T) {
+	priv, pub := DefaultScheme.GenerateKeypair(rand.Reader)
+
+	pubData, err := pub.MarshalBinary()
+	if err != nil {
+		t.Fatalf("publicKey.MarshalBinary: %v", err)
+	}
+	gotPub, err := UnmarshalPublicKey(pubData)
+	if err != nil {
+		t.Fatalf("UnmarshalPublicKey: %v", err)
+	}
+	if !gotPub.Equal(pub) {
+		t.Fatal("public key round trip through Marshal/UnmarshalBinary changed the key")
+	}
+
+	privData, err := priv.MarshalBinary()
+	if err != nil {
+		t.Fatalf("privateKey.MarshalBinary: %v", err)
+	}
+	gotPriv, err := UnmarshalPrivateKey(privData)
+	if err != nil {
+		t.Fatalf("UnmarshalPrivateKey: %v", err)
+	}
+	if !bytes.Equal(gotPriv.Bytes(), priv.Bytes()) {
+		t.Fatal("private key round trip through Marshal/UnmarshalBinary changed the key")
+	}
+}
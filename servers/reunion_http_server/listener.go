@@ -0,0 +1,315 @@
+// listener.go - Hardened reunion transports.
+// Copyright (C) 2022  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/epochtime"
+	"github.com/katzenpost/reunion/commands"
+	"github.com/katzenpost/reunion/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"gopkg.in/op/go-logging.v1"
+)
+
+// maxQueryBytes bounds request bodies read from a client before the
+// command is even parsed. It should track the largest legal
+// reunion/commands message; until that size is exported from the
+// commands package itself, this is a conservative fixed cap.
+const maxQueryBytes = 64 * 1024
+
+// epochSkewLimit is how many epochs away from the current one a query
+// is still allowed to claim before it is rejected as stale.
+const epochSkewLimit = 1
+
+var (
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "katzenpost",
+		Subsystem: "reunion_server",
+		Name:      "queue_depth",
+		Help:      "Number of reunion queries currently being processed.",
+	})
+	epochSkewMetric = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "katzenpost",
+		Subsystem: "reunion_server",
+		Name:      "epoch_skew",
+		Help:      "Difference between the server's current epoch and a query's claimed epoch.",
+	})
+	clientErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "katzenpost",
+		Subsystem: "reunion_server",
+		Name:      "client_errors_total",
+		Help:      "Per-client error counts, keyed by client fingerprint.",
+	}, []string{"client"})
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth, epochSkewMetric, clientErrors)
+}
+
+// Listener is a reunion server transport: something that accepts
+// framed queries, routes them through commands.FromBytes and
+// s.ProcessQuery, and writes back the serialized reply.
+type Listener interface {
+	// Serve runs the listener until Close is called or it hits a fatal
+	// error.
+	Serve() error
+
+	// Close shuts the listener down.
+	Close() error
+}
+
+// epochTagged is implemented by reunion commands that carry an epoch,
+// letting processQuery reject stale-epoch queries before they reach
+// ProcessQuery. Commands that don't implement it skip the epoch check.
+type epochTagged interface {
+	Epoch() uint64
+}
+
+// processQuery decodes raw into a reunion command, rejects it fast if
+// it's for a stale epoch, and otherwise forwards it to s.ProcessQuery,
+// returning the serialized reply. It is shared by every Listener
+// implementation so size and epoch checks and metrics stay in one
+// place; rate limiting is done by the Listener before it calls this.
+func processQuery(s *server.Server, log *logging.Logger, client string, raw []byte) ([]byte, error) {
+	queueDepth.Inc()
+	defer queueDepth.Dec()
+
+	if err := validateQuerySize(raw); err != nil {
+		clientErrors.WithLabelValues(client).Inc()
+		return nil, err
+	}
+	cmd, err := commands.FromBytes(raw)
+	if err != nil {
+		clientErrors.WithLabelValues(client).Inc()
+		return nil, fmt.Errorf("invalid query command: %w", err)
+	}
+	if ec, ok := cmd.(epochTagged); ok {
+		current, _, _ := epochtime.Now()
+		skew := epochSkew(current, ec.Epoch())
+		epochSkewMetric.Observe(float64(skew))
+		if epochSkewOutOfRange(skew) {
+			clientErrors.WithLabelValues(client).Inc()
+			return nil, fmt.Errorf("query epoch %d is too far from current epoch %d", ec.Epoch(), current)
+		}
+	}
+	reply, err := s.ProcessQuery(cmd)
+	if err != nil {
+		clientErrors.WithLabelValues(client).Inc()
+		return nil, fmt.Errorf("invalid reply command: %w", err)
+	}
+	return reply.ToBytes(), nil
+}
+
+// validateQuerySize rejects a query body before it is even parsed,
+// catching both the empty-body and oversized-body cases.
+func validateQuerySize(raw []byte) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("empty query")
+	}
+	if len(raw) > maxQueryBytes {
+		return fmt.Errorf("query of %d bytes exceeds max size %d", len(raw), maxQueryBytes)
+	}
+	return nil
+}
+
+// epochSkew returns how many epochs current is ahead of claimed,
+// negative when claimed is in the future.
+func epochSkew(current, claimed uint64) int64 {
+	return int64(current) - int64(claimed)
+}
+
+// epochSkewOutOfRange reports whether skew (as returned by epochSkew)
+// falls outside the tolerance allowed by epochSkewLimit, in either
+// direction.
+func epochSkewOutOfRange(skew int64) bool {
+	return skew > epochSkewLimit || skew < -epochSkewLimit
+}
+
+// staleLimiterTTL bounds how long a client's rate limiter is kept
+// around after its last request. Without this, a churning or
+// adversarial set of client fingerprints could grow rateLimiterSet's
+// map for the life of the process; a fixed mix/authority client set
+// would never hit it, but nothing here assumes the client set is
+// fixed.
+const staleLimiterTTL = 10 * time.Minute
+
+// nowFunc is time.Now, overridable in tests so stale-limiter eviction
+// can be exercised without actually waiting out staleLimiterTTL.
+var nowFunc = time.Now
+
+// limiterEntry pairs a client's token bucket with the last time it was
+// used, so rateLimiterSet.allow can evict entries idle past
+// staleLimiterTTL.
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// rateLimiterSet hands out a token-bucket rate.Limiter per client,
+// created lazily on first use and evicted after staleLimiterTTL of
+// disuse.
+type rateLimiterSet struct {
+	sync.Mutex
+	limiters map[string]*limiterEntry
+	r        rate.Limit
+	burst    int
+}
+
+func newRateLimiterSet(r rate.Limit, burst int) *rateLimiterSet {
+	return &rateLimiterSet{
+		limiters: make(map[string]*limiterEntry),
+		r:        r,
+		burst:    burst,
+	}
+}
+
+func (s *rateLimiterSet) allow(client string) bool {
+	now := nowFunc()
+	s.Lock()
+	defer s.Unlock()
+
+	entry, ok := s.limiters[client]
+	if !ok {
+		entry = &limiterEntry{limiter: rate.NewLimiter(s.r, s.burst)}
+		s.limiters[client] = entry
+	}
+	entry.lastUsed = now
+	s.evictStaleLocked(now)
+	return entry.limiter.Allow()
+}
+
+// evictStaleLocked drops limiters idle for longer than staleLimiterTTL.
+// Callers must hold s.Mutex.
+func (s *rateLimiterSet) evictStaleLocked(now time.Time) {
+	for client, entry := range s.limiters {
+		if now.Sub(entry.lastUsed) > staleLimiterTTL {
+			delete(s.limiters, client)
+		}
+	}
+}
+
+// HTTPSListener is a hardened reunion transport: it requires client
+// certificate mTLS, rate-limits each client with a token bucket, caps
+// the request body at maxQueryBytes, and rejects stale-epoch queries
+// before they reach ProcessQuery. It replaces the plaintext,
+// unauthenticated HTTP handler that used to accept any POST body.
+type HTTPSListener struct {
+	httpServer *http.Server
+	rsrv       *server.Server
+	log        *logging.Logger
+	limiters   *rateLimiterSet
+}
+
+// NewHTTPSListener constructs an HTTPSListener bound to address,
+// serving urlPath, presenting cert to connecting clients and requiring
+// their client certificates to chain to clientCAs. rateLimit and burst
+// configure the per-client token bucket.
+func NewHTTPSListener(address, urlPath string, cert tls.Certificate, clientCAs *x509.CertPool, rsrv *server.Server, log *logging.Logger, rateLimit rate.Limit, burst int) *HTTPSListener {
+	l := &HTTPSListener{
+		rsrv:     rsrv,
+		log:      log,
+		limiters: newRateLimiterSet(rateLimit, burst),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(urlPath, l.handle)
+	l.httpServer = &http.Server{
+		Addr:           address,
+		Handler:        mux,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+			MinVersion:   tls.VersionTLS13,
+		},
+	}
+	return l
+}
+
+// clientFingerprint identifies the connecting client for rate limiting
+// and error-count metrics. Operators are expected to issue client
+// certificates whose CommonName is the hex-encoded wire.PublicKey
+// fingerprint (PublicKey.Sum256) the client authenticates with
+// elsewhere in the mixnet, so this label lines up with the rest of
+// Katzenpost's client identifiers.
+func clientFingerprint(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return "unknown"
+	}
+	return req.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+func (l *HTTPSListener) handle(w http.ResponseWriter, req *http.Request) {
+	client := clientFingerprint(req)
+	if !l.limiters.allow(client) {
+		l.log.Warningf("rate limited client %s", client)
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+
+	body := http.MaxBytesReader(w, req.Body, maxQueryBytes)
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		l.log.Errorf("reading request body from %s: %s", client, err)
+		clientErrors.WithLabelValues(client).Inc()
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	reply, err := processQuery(l.rsrv, l.log, client, raw)
+	if err != nil {
+		l.log.Errorf("%s: %s", client, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if _, err := w.Write(reply); err != nil {
+		l.log.Errorf("writing reply to %s: %s", client, err)
+	}
+}
+
+// Serve runs the HTTPS listener until Close is called.
+func (l *HTTPSListener) Serve() error {
+	return l.httpServer.ListenAndServeTLS("", "")
+}
+
+// Close shuts the HTTPS listener down.
+func (l *HTTPSListener) Close() error {
+	return l.httpServer.Shutdown(context.Background())
+}
+
+// A length-prefixed, Noise-KK-authenticated Listener over the wire
+// package's KEM scheme (the framing-only scaffold that used to live
+// here as FramedListener) is deliberately not implemented yet: it
+// needs a Noise session state machine to actually authenticate the
+// peer, and this snapshot of the wire package only has KEM key types
+// (see core/wire), not that state machine. Shipping the framing
+// without the handshake would just be a second unauthenticated
+// net.Listener accepting arbitrary commands.FromBytes input -- the
+// same hole HTTPSListener above was written to close. Add it back,
+// wired through an authenticated Noise-KK session, once wire grows
+// one.
@@ -0,0 +1,124 @@
+// listener_test.go - Tests for query validation and rate limiting.
+// Copyright (C) 2022  David Stainton.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// validateQuerySize, epochSkew and epochSkewOutOfRange are the pure
+// decision logic processQuery runs before a query reaches the opaque
+// reunion/server ProcessQuery; the reunion/commands and reunion/server
+// types are not vendored in this snapshot of the tree and so can't be
+// faked convincingly. Testing
+// them directly exercises the oversized/empty/stale-epoch behavior
+// processQuery is responsible for without needing a real command
+// round trip.
+
+func TestValidateQuerySizeRejectsEmptyBody(t *testing.T) {
+	if err := validateQuerySize(nil); err == nil {
+		t.Fatal("empty query should have been rejected")
+	}
+	if err := validateQuerySize([]byte{}); err == nil {
+		t.Fatal("empty query should have been rejected")
+	}
+}
+
+func TestValidateQuerySizeRejectsOversizedBody(t *testing.T) {
+	raw := make([]byte, maxQueryBytes+1)
+	if err := validateQuerySize(raw); err == nil {
+		t.Fatal("oversized query should have been rejected")
+	}
+}
+
+func TestValidateQuerySizeAcceptsBodyWithinLimit(t *testing.T) {
+	raw := make([]byte, maxQueryBytes)
+	if err := validateQuerySize(raw); err != nil {
+		t.Fatalf("query at the size limit should have been accepted: %v", err)
+	}
+}
+
+func TestEpochSkewOutOfRangeBothDirections(t *testing.T) {
+	cases := []struct {
+		skew    int64
+		outside bool
+	}{
+		{skew: 0, outside: false},
+		{skew: epochSkewLimit, outside: false},
+		{skew: -epochSkewLimit, outside: false},
+		{skew: epochSkewLimit + 1, outside: true},
+		{skew: -(epochSkewLimit + 1), outside: true},
+	}
+	for _, c := range cases {
+		if got := epochSkewOutOfRange(c.skew); got != c.outside {
+			t.Errorf("epochSkewOutOfRange(%d) = %v, want %v", c.skew, got, c.outside)
+		}
+	}
+}
+
+func TestEpochSkewStaleAndFuture(t *testing.T) {
+	const current = uint64(100)
+
+	if skew := epochSkew(current, current-2); !epochSkewOutOfRange(skew) {
+		t.Fatal("a query claiming an epoch 2 behind current should be rejected as stale")
+	}
+	if skew := epochSkew(current, current+2); !epochSkewOutOfRange(skew) {
+		t.Fatal("a query claiming an epoch 2 ahead of current should be rejected as too far in the future")
+	}
+	if skew := epochSkew(current, current); epochSkewOutOfRange(skew) {
+		t.Fatal("a query claiming the current epoch should be accepted")
+	}
+}
+
+func TestRateLimiterSetAllowsUpToBurstThenLimits(t *testing.T) {
+	s := newRateLimiterSet(rate.Limit(1), 2)
+
+	if !s.allow("alice") {
+		t.Fatal("first request within burst should be allowed")
+	}
+	if !s.allow("alice") {
+		t.Fatal("second request within burst should be allowed")
+	}
+	if s.allow("alice") {
+		t.Fatal("request past burst should be rate limited")
+	}
+	if !s.allow("bob") {
+		t.Fatal("a different client should have its own independent limiter")
+	}
+}
+
+func TestRateLimiterSetEvictsStaleClients(t *testing.T) {
+	s := newRateLimiterSet(rate.Limit(1), 1)
+	prev := nowFunc
+	defer func() { nowFunc = prev }()
+
+	base := time.Now()
+	nowFunc = func() time.Time { return base }
+	s.allow("alice")
+	if _, ok := s.limiters["alice"]; !ok {
+		t.Fatal("allow did not create a limiter entry for alice")
+	}
+
+	nowFunc = func() time.Time { return base.Add(staleLimiterTTL + time.Second) }
+	s.allow("bob")
+	if _, ok := s.limiters["alice"]; ok {
+		t.Fatal("allow did not evict alice's stale limiter entry")
+	}
+}
@@ -17,74 +17,58 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
-	"io/ioutil"
-	"net/http"
-	"time"
+	"os"
 
 	"github.com/katzenpost/core/log"
-	"github.com/katzenpost/reunion/commands"
 	"github.com/katzenpost/reunion/server"
-	"gopkg.in/op/go-logging.v1"
+	"golang.org/x/time/rate"
 )
 
-func httpReunionServerFactory(s *server.Server, log *logging.Logger) func(w http.ResponseWriter, req *http.Request) {
-	return func(w http.ResponseWriter, req *http.Request) {
+const (
+	defaultRateLimit = rate.Limit(5)
+	defaultBurst     = 10
+)
 
-		rawRequest, err := ioutil.ReadAll(req.Body)
-		if err != nil {
-			log.Errorf("reunion HTTP server failed to ReadAll raw command data: %s", err.Error())
-			return
-		}
-		log.Debugf("raw request size is %d", len(rawRequest))
-		if len(rawRequest) == 0 {
-			log.Error("error read zero sized request body from client")
-			return
-		}
-		cmd, err := commands.FromBytes(rawRequest)
-		if err != nil {
-			log.Errorf("1 reunion HTTP server invalid query command: %s", err.Error())
-			return
-		}
-		replyCmd, err := s.ProcessQuery(cmd)
-		if err != nil {
-			log.Errorf("reunion HTTP server invalid reply command: %s", err.Error())
-			return
-		}
-		rawReply := replyCmd.ToBytes()
-		_, err = w.Write(rawReply)
-		if err != nil {
-			log.Errorf("reunion HTTP server failure to send reply command: %s", err.Error())
-			return
-		}
-	}
-}
+func main() {
+	address := flag.String("l", "127.0.0.1:12345", "Listen address. Defaults to 127.0.0.1:12345")
+	urlPath := flag.String("p", "/reunion", "Reunion URL path.")
+	logPath := flag.String("log", "", "Log file path. Default STDOUT.")
+	logLevel := flag.String("level", "DEBUG", "Log level.")
+	certFile := flag.String("cert", "", "TLS server certificate (required).")
+	keyFile := flag.String("key", "", "TLS server certificate key (required).")
+	clientCAFile := flag.String("client-ca", "", "PEM file of CAs trusted to sign client certificates (required).")
+	flag.Parse()
 
-func runHTTPServer(address, urlPath, logPath, logLevel string) *http.Server {
-	logBackend, err := log.New(logPath, logLevel, false)
+	logBackend, err := log.New(*logPath, *logLevel, false)
 	if err != nil {
 		panic(err)
 	}
-	reunionServer := server.NewServer()
-	httpServeMux := http.NewServeMux()
 	httpLog := logBackend.GetLogger("reunion_http_server")
-	httpServeMux.HandleFunc(urlPath, httpReunionServerFactory(reunionServer, httpLog))
-	httpServer := &http.Server{
-		Addr:           address,
-		Handler:        httpServeMux,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+	reunionServer := server.NewServer()
+
+	if *certFile == "" || *keyFile == "" || *clientCAFile == "" {
+		httpLog.Error("-cert, -key and -client-ca are all required: the plaintext, unauthenticated HTTP listener has been removed")
+		os.Exit(1)
 	}
-	go httpServer.ListenAndServe()
-	return httpServer
-}
 
-func main() {
-	address := flag.String("l", "127.0.0.1:12345", "Listen address. Defaults to 127.0.0.1:12345")
-	urlPath := flag.String("p", "/reunion", "Reunion URL path.")
-	logPath := flag.String("log", "", "Log file path. Default STDOUT.")
-	logLevel := flag.String("level", "DEBUG", "Log level.")
-	flag.Parse()
-	runHTTPServer(*address, *urlPath, *logPath, *logLevel)
+	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+	if err != nil {
+		panic(err)
+	}
+	caPEM, err := os.ReadFile(*clientCAFile)
+	if err != nil {
+		panic(err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		panic("failed to parse client CA PEM")
+	}
+
+	httpsListener := NewHTTPSListener(*address, *urlPath, cert, clientCAs, reunionServer, httpLog, defaultRateLimit, defaultBurst)
+	if err := httpsListener.Serve(); err != nil {
+		httpLog.Errorf("https listener exited: %s", err)
+	}
 }